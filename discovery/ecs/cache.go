@@ -0,0 +1,107 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/discovery/ecs/types"
+)
+
+// cache persists the most recent successful ServiceInstance snapshot of a
+// discoverer (one account/region pair) as a JSON file on disk, so
+// Discovery.Run can emit targets immediately on startup instead of waiting
+// for a full ECS API walk, and keep serving them through a transient API
+// outage. A nil *cache is valid and makes every method a no-op, so
+// Discovery works unchanged when CacheDir is unset.
+type cache struct {
+	dir string
+	ttl time.Duration
+}
+
+// newCache returns a cache rooted at dir, or nil if dir is empty.
+func newCache(dir string, ttl time.Duration) *cache {
+	if dir == "" {
+		return nil
+	}
+	return &cache{dir: dir, ttl: ttl}
+}
+
+// cacheEntry is the on-disk representation of a single cached snapshot.
+type cacheEntry struct {
+	SavedAt   time.Time                `json:"saved_at"`
+	Instances []*types.ServiceInstance `json:"instances"`
+}
+
+// path returns the on-disk path of key's snapshot.
+func (c *cache) path(key string) string {
+	return filepath.Join(c.dir, strings.Replace(key, "/", "_", -1)+".json")
+}
+
+// load returns the cached snapshot for key, if any, along with whether it is
+// still within ttl. A snapshot past its ttl is still returned, with
+// fresh=false, so callers deciding whether to serve stale targets on error
+// can tell a hard-expired cache from a merely absent one.
+func (c *cache) load(key string) (instances []*types.ServiceInstance, fresh bool, err error) {
+	if c == nil {
+		return nil, false, nil
+	}
+
+	data, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, err
+	}
+
+	fresh = c.ttl <= 0 || time.Since(entry.SavedAt) <= c.ttl
+	return entry.Instances, fresh, nil
+}
+
+// save persists instances as key's snapshot, replacing any previous one.
+func (c *cache) save(key string, instances []*types.ServiceInstance) error {
+	if c == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("could not create ECS SD cache directory: %s", err)
+	}
+
+	data, err := json.Marshal(cacheEntry{SavedAt: time.Now(), Instances: instances})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename into place so a crash or
+	// concurrent read never observes a partially written snapshot.
+	path := c.path(key)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
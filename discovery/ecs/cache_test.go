@@ -0,0 +1,82 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prometheus/prometheus/discovery/ecs/types"
+)
+
+func TestCacheNilIsNoOp(t *testing.T) {
+	assert := assert.New(t)
+
+	var c *cache
+	assert.NoError(c.save("key", []*types.ServiceInstance{{Cluster: "infra"}}))
+
+	instances, fresh, err := c.load("key")
+	assert.NoError(err)
+	assert.False(fresh)
+	assert.Nil(instances)
+}
+
+func TestCacheSaveLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "ecs-sd-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	c := newCache(dir, 0)
+	instances := []*types.ServiceInstance{
+		{Cluster: "infra", Service: "api", Addr: "10.0.0.1:8080"},
+	}
+
+	assert.NoError(c.save("ecs/prod/us-east-1", instances))
+
+	got, fresh, err := c.load("ecs/prod/us-east-1")
+	assert.NoError(err)
+	assert.True(fresh)
+	assert.Equal(instances, got)
+
+	// A key that was never saved comes back empty, not an error.
+	got, fresh, err = c.load("ecs/staging/us-west-2")
+	assert.NoError(err)
+	assert.False(fresh)
+	assert.Nil(got)
+}
+
+func TestCacheTTLExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "ecs-sd-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	c := newCache(dir, time.Millisecond)
+	instances := []*types.ServiceInstance{{Cluster: "infra"}}
+	assert.NoError(c.save("ecs/prod/us-east-1", instances))
+
+	time.Sleep(5 * time.Millisecond)
+
+	got, fresh, err := c.load("ecs/prod/us-east-1")
+	assert.NoError(err)
+	assert.False(fresh, "snapshot should be past its ttl")
+	assert.Equal(instances, got, "expired snapshot is still returned so callers can decide what to do with it")
+}
@@ -0,0 +1,298 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client knows how to talk to the AWS ECS and EC2 APIs to discover
+// the running tasks of a cluster.
+package client
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+
+	"github.com/prometheus/prometheus/discovery/ecs/types"
+)
+
+// Retriever retrieves the currently running ECS service instances.
+type Retriever interface {
+	Retrieve() ([]*types.ServiceInstance, error)
+}
+
+// APICallRecorder is notified every time the client issues an AWS API call,
+// so callers can correlate discovery load with API throttling.
+type APICallRecorder interface {
+	IncAPICall(endpoint string)
+}
+
+// Client is a Retriever that talks to the real AWS ECS/EC2 APIs.
+type Client struct {
+	region  string
+	ecs     *ecs.ECS
+	ec2     *ec2.EC2
+	metrics APICallRecorder
+}
+
+// New creates a Client for the given region. If roleARN is empty,
+// credentials are resolved through the default AWS SDK credential chain;
+// otherwise they are obtained by assuming roleARN (optionally passing
+// externalID, as required by some cross-account trust policies) from those
+// default credentials, and transparently refreshed via stscreds as they
+// near expiry, so long-running discoverers survive session expiry. metrics
+// may be nil.
+func New(region, roleARN, externalID string, metrics APICallRecorder) (*Client, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("could not create aws session: %s", err)
+	}
+
+	cfg := &aws.Config{Region: aws.String(region)}
+	if roleARN != "" {
+		cfg.Credentials = stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+			if externalID != "" {
+				p.ExternalID = aws.String(externalID)
+			}
+		})
+	}
+
+	return &Client{
+		region:  region,
+		ecs:     ecs.New(sess, cfg),
+		ec2:     ec2.New(sess, cfg),
+		metrics: metrics,
+	}, nil
+}
+
+// recordCall reports an AWS API call to the configured APICallRecorder, if
+// any.
+func (c *Client) recordCall(endpoint string) {
+	if c.metrics != nil {
+		c.metrics.IncAPICall(endpoint)
+	}
+}
+
+// Retrieve walks every cluster visible to the configured credentials and
+// returns one ServiceInstance per published container port.
+func (c *Client) Retrieve() ([]*types.ServiceInstance, error) {
+	clusters, err := c.listClusters()
+	if err != nil {
+		return nil, fmt.Errorf("could not list clusters: %w", err)
+	}
+
+	var instances []*types.ServiceInstance
+	for _, cluster := range clusters {
+		is, err := c.retrieveCluster(cluster)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve cluster %q: %w", cluster, err)
+		}
+		instances = append(instances, is...)
+	}
+
+	return instances, nil
+}
+
+func (c *Client) listClusters() ([]string, error) {
+	c.recordCall("ListClusters")
+	var clusters []string
+	err := c.ecs.ListClustersPages(&ecs.ListClustersInput{}, func(out *ecs.ListClustersOutput, lastPage bool) bool {
+		for _, arn := range out.ClusterArns {
+			clusters = append(clusters, aws.StringValue(arn))
+		}
+		// Each further page is fetched with its own API call.
+		if !lastPage {
+			c.recordCall("ListClusters")
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// retrieveCluster lists the running tasks of a cluster, describes them along
+// with their container instances and task definitions, and flattens the
+// result into one ServiceInstance per published container port.
+func (c *Client) retrieveCluster(cluster string) ([]*types.ServiceInstance, error) {
+	c.recordCall("ListTasks")
+	var taskArns []*string
+	err := c.ecs.ListTasksPages(&ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	}, func(out *ecs.ListTasksOutput, lastPage bool) bool {
+		taskArns = append(taskArns, out.TaskArns...)
+		if !lastPage {
+			c.recordCall("ListTasks")
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list tasks: %w", err)
+	}
+	if len(taskArns) == 0 {
+		return nil, nil
+	}
+
+	c.recordCall("DescribeTasks")
+	tasksOut, err := c.ecs.DescribeTasks(&ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   taskArns,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe tasks: %w", err)
+	}
+
+	taskDefCache := map[string]map[string]map[string]string{}
+	nodeCache := map[string]containerInstanceNode{}
+
+	var instances []*types.ServiceInstance
+	for _, task := range tasksOut.Tasks {
+		node, err := c.containerInstanceNode(nodeCache, cluster, aws.StringValue(task.ContainerInstanceArn))
+		if err != nil {
+			return nil, err
+		}
+		containerLabels, err := c.taskDefinitionLabels(taskDefCache, aws.StringValue(task.TaskDefinitionArn))
+		if err != nil {
+			return nil, err
+		}
+		for _, container := range task.Containers {
+			for _, binding := range container.NetworkBindings {
+				instances = append(instances, &types.ServiceInstance{
+					Cluster:            cluster,
+					Service:            serviceNameFromTask(task),
+					Addr:               fmt.Sprintf("%s:%d", node.host, aws.Int64Value(binding.HostPort)),
+					Container:          aws.StringValue(container.Name),
+					ContainerPort:      fmt.Sprintf("%d", aws.Int64Value(binding.ContainerPort)),
+					ContainerPortProto: aws.StringValue(binding.Protocol),
+					Image:              aws.StringValue(container.Image),
+					Labels:             containerLabels[aws.StringValue(container.Name)],
+					Tags:               node.tags,
+				})
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// containerInstanceNode is the EC2 host backing an ECS container instance:
+// the private IP Prometheus should scrape and the tags set on that EC2
+// instance, exposed as __meta_ecs_node_tag_* labels.
+type containerInstanceNode struct {
+	host string
+	tags map[string]string
+}
+
+// containerInstanceNode resolves the EC2 instance backing
+// containerInstanceArn. Results are memoized in cache since many tasks on a
+// busy cluster share the same container instance.
+func (c *Client) containerInstanceNode(cache map[string]containerInstanceNode, cluster, containerInstanceArn string) (containerInstanceNode, error) {
+	if node, ok := cache[containerInstanceArn]; ok {
+		return node, nil
+	}
+
+	c.recordCall("DescribeContainerInstances")
+	out, err := c.ecs.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
+		Cluster:            aws.String(cluster),
+		ContainerInstances: []*string{aws.String(containerInstanceArn)},
+	})
+	if err != nil {
+		return containerInstanceNode{}, fmt.Errorf("could not describe container instance: %w", err)
+	}
+	if len(out.ContainerInstances) == 0 {
+		return containerInstanceNode{}, fmt.Errorf("container instance %q not found", containerInstanceArn)
+	}
+
+	instanceID := aws.StringValue(out.ContainerInstances[0].Ec2InstanceId)
+	c.recordCall("DescribeInstances")
+	ec2Out, err := c.ec2.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		return containerInstanceNode{}, fmt.Errorf("could not describe ec2 instance %q: %w", instanceID, err)
+	}
+	if len(ec2Out.Reservations) == 0 || len(ec2Out.Reservations[0].Instances) == 0 {
+		return containerInstanceNode{}, fmt.Errorf("ec2 instance %q not found", instanceID)
+	}
+
+	instance := ec2Out.Reservations[0].Instances[0]
+	tags := make(map[string]string, len(instance.Tags))
+	for _, t := range instance.Tags {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+
+	node := containerInstanceNode{
+		host: aws.StringValue(instance.PrivateIpAddress),
+		tags: tags,
+	}
+	cache[containerInstanceArn] = node
+
+	return node, nil
+}
+
+// taskDefinitionLabels returns, for a given task definition ARN, the docker
+// labels of each of its container definitions keyed by container name.
+// Results are memoized in cache since every task produced by the same
+// service shares the same task definition.
+func (c *Client) taskDefinitionLabels(cache map[string]map[string]map[string]string, taskDefinitionArn string) (map[string]map[string]string, error) {
+	if labels, ok := cache[taskDefinitionArn]; ok {
+		return labels, nil
+	}
+
+	c.recordCall("DescribeTaskDefinition")
+	out, err := c.ecs.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinitionArn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe task definition %q: %w", taskDefinitionArn, err)
+	}
+
+	labels := containerLabelsFromDefinition(out.TaskDefinition)
+	cache[taskDefinitionArn] = labels
+
+	return labels, nil
+}
+
+// containerLabelsFromDefinition extracts the docker labels of each container
+// definition of def, keyed by container name. Containers without docker
+// labels are omitted.
+func containerLabelsFromDefinition(def *ecs.TaskDefinition) map[string]map[string]string {
+	labels := make(map[string]map[string]string, len(def.ContainerDefinitions))
+	for _, cd := range def.ContainerDefinitions {
+		if len(cd.DockerLabels) == 0 {
+			continue
+		}
+		m := make(map[string]string, len(cd.DockerLabels))
+		for k, v := range cd.DockerLabels {
+			m[k] = aws.StringValue(v)
+		}
+		labels[aws.StringValue(cd.Name)] = m
+	}
+	return labels
+}
+
+func serviceNameFromTask(task *ecs.Task) string {
+	if task.Group == nil {
+		return ""
+	}
+	// Task groups for service managed tasks are formatted as "service:<name>".
+	const prefix = "service:"
+	g := aws.StringValue(task.Group)
+	if len(g) > len(prefix) && g[:len(prefix)] == prefix {
+		return g[len(prefix):]
+	}
+	return g
+}
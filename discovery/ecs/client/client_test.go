@@ -0,0 +1,51 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerLabelsFromDefinition(t *testing.T) {
+	assert := assert.New(t)
+
+	def := &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{
+				Name: aws.String("web"),
+				DockerLabels: map[string]*string{
+					"prometheus.io/scrape": aws.String("true"),
+					"team":                 aws.String("infra"),
+				},
+			},
+			{
+				Name:         aws.String("sidecar"),
+				DockerLabels: map[string]*string{},
+			},
+		},
+	}
+
+	labels := containerLabelsFromDefinition(def)
+
+	assert.Equal(map[string]string{
+		"prometheus.io/scrape": "true",
+		"team":                 "infra",
+	}, labels["web"])
+	_, ok := labels["sidecar"]
+	assert.False(ok, "a container without docker labels should not get an entry")
+}
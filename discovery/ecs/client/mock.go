@@ -0,0 +1,50 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import "github.com/prometheus/prometheus/discovery/ecs/types"
+
+// MockRetriever is a Retriever that returns a fixed, preconfigured list of
+// instances. It is used by the discoverer's tests so they don't need real
+// AWS credentials.
+type MockRetriever struct {
+	Instances []*types.ServiceInstance
+	Err       error
+
+	// Errs, if non-empty, overrides Err with a per-call error sequence:
+	// the n-th call to Retrieve returns Errs[n] (the last entry is reused
+	// once exhausted), so tests can simulate a retriever that starts
+	// failing after a successful cycle.
+	Errs []error
+
+	calls int
+}
+
+// Retrieve implements Retriever.
+func (m *MockRetriever) Retrieve() ([]*types.ServiceInstance, error) {
+	err := m.Err
+	if len(m.Errs) > 0 {
+		i := m.calls
+		if i >= len(m.Errs) {
+			i = len(m.Errs) - 1
+		}
+		err = m.Errs[i]
+	}
+	m.calls++
+
+	if err != nil {
+		return nil, err
+	}
+	return m.Instances, nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery/ecs/client"
+	"github.com/prometheus/prometheus/discovery/ecs/matcher"
+	"github.com/prometheus/prometheus/discovery/ecs/types"
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// discoverer periodically performs ECS-SD requests against a single AWS
+// account/region pair. Discovery owns one discoverer per configured
+// AccountConfig.
+type discoverer struct {
+	source   string
+	account  string
+	region   string
+	interval time.Duration
+	client   client.Retriever
+	logger   log.Logger
+	matchers matcher.Pipeline
+	metrics  *Metrics
+
+	// cache, if non-nil, persists this discoverer's last successful
+	// snapshot to disk, and serveStaleOnError decides whether a failed
+	// refresh falls back to it instead of emitting nothing.
+	cache             *cache
+	serveStaleOnError bool
+}
+
+// run refreshes this account/region's targets until ctx is cancelled,
+// sending updates on ch. It does not close ch: several discoverers share it,
+// and Discovery.Run owns closing it once they have all returned.
+func (d *discoverer) run(ctx context.Context, ch chan<- []*config.TargetGroup) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	if tg := d.cachedTargetGroup(); tg != nil {
+		select {
+		case ch <- []*config.TargetGroup{tg}:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	for {
+		tg, err := d.refresh()
+		if err != nil {
+			d.logger.Errorf("error refreshing ECS targets for %s: %s", d.source, err)
+		} else {
+			select {
+			case ch <- []*config.TargetGroup{tg}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cachedTargetGroup returns the TargetGroup built from this discoverer's
+// last cached snapshot, or nil if no cache is configured or it holds
+// nothing usable. It is used to emit targets immediately on startup,
+// before the first live refresh completes.
+func (d *discoverer) cachedTargetGroup() *config.TargetGroup {
+	if d.cache == nil {
+		return nil
+	}
+	instances, fresh, err := d.cache.load(d.source)
+	if err != nil {
+		d.logger.Warnf("could not load ECS SD cache for %s: %s", d.source, err)
+		return nil
+	}
+	if !fresh || len(instances) == 0 {
+		return nil
+	}
+	return d.buildTargetGroup(instances)
+}
+
+// refresh retrieves the current ECS service instances and turns them into a
+// single TargetGroup, applying the configured matcher pipeline. If the
+// retrieval fails with a retryable error and ServeStaleOnError is set, it
+// falls back to the last cached snapshot instead of failing outright.
+func (d *discoverer) refresh() (*config.TargetGroup, error) {
+	start := time.Now()
+	instances, apiErr := d.client.Retrieve()
+	d.metrics.observeRefresh(time.Since(start), apiErr)
+
+	if apiErr != nil {
+		if d.cache != nil && d.serveStaleOnError && isRetryableError(apiErr) {
+			cached, fresh, err := d.cache.load(d.source)
+			if err == nil && fresh && len(cached) > 0 {
+				d.logger.Warnf("error refreshing ECS targets for %s: %s; serving last cached targets", d.source, apiErr)
+				return d.buildTargetGroup(cached), nil
+			}
+		}
+		return nil, apiErr
+	}
+
+	if d.cache != nil {
+		if err := d.cache.save(d.source, instances); err != nil {
+			d.logger.Warnf("could not persist ECS SD cache for %s: %s", d.source, err)
+		}
+	}
+
+	return d.buildTargetGroup(instances), nil
+}
+
+// buildTargetGroup turns a set of ServiceInstances into this discoverer's
+// TargetGroup, applying the configured matcher pipeline and updating the
+// last-cycle count metrics.
+func (d *discoverer) buildTargetGroup(instances []*types.ServiceInstance) *config.TargetGroup {
+	clusters := map[string]bool{}
+	services := map[string]bool{}
+	for _, inst := range instances {
+		clusters[inst.Cluster] = true
+		services[inst.Cluster+"/"+inst.Service] = true
+	}
+
+	tg := &config.TargetGroup{
+		Source: d.source,
+	}
+
+	// No matchers configured: preserve the historical behaviour of emitting
+	// every published port as its own target.
+	if len(d.matchers) == 0 {
+		for _, inst := range instances {
+			tg.Targets = append(tg.Targets, d.instanceLabels(inst, ""))
+		}
+		d.metrics.setCounts(len(clusters), len(services), len(tg.Targets))
+		return tg
+	}
+
+	for _, group := range groupByContainer(instances) {
+		name, ports, ok := d.matchers.Match(group.container())
+		if !ok {
+			continue
+		}
+		selected := make(map[string]bool, len(ports))
+		for _, p := range ports {
+			selected[p.Number] = true
+		}
+		for _, inst := range group.instances {
+			if !selected[inst.ContainerPort] {
+				continue
+			}
+			tg.Targets = append(tg.Targets, d.instanceLabels(inst, name))
+		}
+	}
+
+	d.metrics.setCounts(len(clusters), len(services), len(tg.Targets))
+	return tg
+}
+
+// instanceLabels builds the meta labels for a single ServiceInstance. When
+// matcherName is non-empty it is exposed via the __meta_ecs_matcher label.
+func (d *discoverer) instanceLabels(inst *types.ServiceInstance, matcherName string) model.LabelSet {
+	labels := model.LabelSet{
+		model.AddressLabel:   model.LabelValue(inst.Addr),
+		ecsLabelAccount:      model.LabelValue(d.account),
+		ecsLabelRegion:       model.LabelValue(d.region),
+		ecsLabelCluster:      model.LabelValue(inst.Cluster),
+		ecsLabelService:      model.LabelValue(inst.Service),
+		ecsLabelImage:        model.LabelValue(inst.Image),
+		ecsLabelContainer:    model.LabelValue(inst.Container),
+		ecsLabelPortNumber:   model.LabelValue(inst.ContainerPort),
+		ecsLabelPortProtocol: model.LabelValue(inst.ContainerPortProto),
+	}
+	if matcherName != "" {
+		labels[ecsLabelMatcher] = model.LabelValue(matcherName)
+	}
+	for k, v := range inst.Labels {
+		name := strutil.SanitizeLabelName(ecsLabelContainerLabel + k)
+		labels[model.LabelName(name)] = model.LabelValue(v)
+	}
+	for k, v := range inst.Tags {
+		name := strutil.SanitizeLabelName(ecsLabelNodeTag + k)
+		labels[model.LabelName(name)] = model.LabelValue(v)
+	}
+	return labels
+}
+
+// containerGroup gathers every ServiceInstance (i.e. published port) that
+// belongs to the same running container.
+type containerGroup struct {
+	name      string
+	labels    map[string]string
+	instances []*types.ServiceInstance
+}
+
+// container builds the matcher.Container view of this group.
+func (g *containerGroup) container() matcher.Container {
+	ports := make([]matcher.Port, 0, len(g.instances))
+	for _, inst := range g.instances {
+		ports = append(ports, matcher.Port{Number: inst.ContainerPort, Protocol: inst.ContainerPortProto})
+	}
+	return matcher.Container{Name: g.name, Labels: g.labels, Ports: ports}
+}
+
+// groupByContainer groups instances sharing the same cluster, service,
+// container name and host address, i.e. the ServiceInstances that came from
+// the same running container but different published ports.
+func groupByContainer(instances []*types.ServiceInstance) []*containerGroup {
+	order := make([]string, 0, len(instances))
+	groups := make(map[string]*containerGroup, len(instances))
+
+	for _, inst := range instances {
+		key := fmt.Sprintf("%s/%s/%s/%s", inst.Cluster, inst.Service, inst.Container, host(inst.Addr))
+		g, ok := groups[key]
+		if !ok {
+			g = &containerGroup{name: inst.Container, labels: inst.Labels}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.instances = append(g.instances, inst)
+	}
+
+	result := make([]*containerGroup, 0, len(order))
+	for _, key := range order {
+		result = append(result, groups[key])
+	}
+	return result
+}
+
+// host strips the port off of a host:port address.
+func host(addr string) string {
+	for i := len(addr) - 1; i >= 0; i-- {
+		if addr[i] == ':' {
+			return addr[:i]
+		}
+	}
+	return addr
+}
@@ -0,0 +1,198 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ecs provides service discovery for Amazon ECS (EC2 Container
+// Service) tasks.
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery/ecs/client"
+	"github.com/prometheus/prometheus/discovery/ecs/matcher"
+)
+
+const (
+	ecsLabel               = model.MetaLabelPrefix + "ecs_"
+	ecsLabelCluster        = ecsLabel + "cluster"
+	ecsLabelService        = ecsLabel + "service"
+	ecsLabelImage          = ecsLabel + "image"
+	ecsLabelContainer      = ecsLabel + "container"
+	ecsLabelPortNumber     = ecsLabel + "container_port_number"
+	ecsLabelPortProtocol   = ecsLabel + "container_port_protocol"
+	ecsLabelContainerLabel = ecsLabel + "container_label_"
+	ecsLabelNodeTag        = ecsLabel + "node_tag_"
+	ecsLabelMatcher        = ecsLabel + "matcher"
+	ecsLabelAccount        = ecsLabel + "account"
+	ecsLabelRegion         = ecsLabel + "region"
+
+	// DefaultRefreshInterval is used when SDConfig.RefreshInterval is unset.
+	DefaultRefreshInterval = model.Duration(60 * time.Second)
+)
+
+// DefaultSDConfig is the default ECS SD configuration.
+var DefaultSDConfig = SDConfig{
+	RefreshInterval: DefaultRefreshInterval,
+}
+
+// AccountConfig is a single AWS account/region pair that ECS SD discovers
+// targets in. When RoleARN is set, the account's credentials are obtained by
+// assuming that role from the discoverer's own credentials.
+type AccountConfig struct {
+	// Region is the AWS region the ECS clusters live in.
+	Region string `yaml:"region"`
+	// RoleARN, if set, is assumed via sts.AssumeRole to obtain this
+	// account's credentials.
+	RoleARN string `yaml:"role_arn,omitempty"`
+	// ExternalID is passed along when assuming RoleARN, as required by
+	// some cross-account role trust policies.
+	ExternalID string `yaml:"external_id,omitempty"`
+	// AccountAlias is a friendly name for this account, used to build the
+	// target group source and the __meta_ecs_account label. Defaults to
+	// Region when unset.
+	AccountAlias string `yaml:"account_alias,omitempty"`
+}
+
+// alias returns the account's friendly alias, defaulting to its region.
+func (a AccountConfig) alias() string {
+	if a.AccountAlias != "" {
+		return a.AccountAlias
+	}
+	return a.Region
+}
+
+// SDConfig is the configuration for ECS based service discovery.
+type SDConfig struct {
+	// Accounts is the list of AWS account/region pairs to discover targets
+	// in.
+	Accounts []AccountConfig `yaml:"accounts"`
+	// RefreshInterval is the time between two discovery refreshes.
+	RefreshInterval model.Duration `yaml:"refresh_interval,omitempty"`
+	// Matchers is the ordered list of rules deciding which (container, port)
+	// pairs become targets. If empty, every published port of every
+	// container is turned into a target, preserving the historical
+	// behaviour.
+	Matchers []matcher.MatcherConfig `yaml:"matchers,omitempty"`
+	// CacheDir, if set, is where the last successful target snapshot of
+	// each account/region is persisted as JSON. It lets Discovery.Run emit
+	// targets immediately on startup instead of waiting for a full ECS API
+	// walk, which can take minutes on large accounts.
+	CacheDir string `yaml:"cache_dir,omitempty"`
+	// CacheTTL is the hard expiry after which a cached snapshot is
+	// discarded even on a failed refresh, to bound how stale served
+	// targets can get. Zero means cached snapshots never hard-expire.
+	CacheTTL model.Duration `yaml:"cache_ttl,omitempty"`
+	// ServeStaleOnError, when true, keeps serving the last cached snapshot
+	// if a refresh fails with a retryable AWS error, instead of sending
+	// nothing for that account/region until the next successful refresh.
+	ServeStaleOnError bool `yaml:"serve_stale_on_error,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultSDConfig
+	type plain SDConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if len(c.Accounts) == 0 {
+		return fmt.Errorf("ECS SD configuration requires at least one account")
+	}
+	for i, a := range c.Accounts {
+		if a.Region == "" {
+			return fmt.Errorf("ECS SD account %d requires a region", i)
+		}
+	}
+	return nil
+}
+
+// Discovery fans out ECS-SD requests across every configured account/region
+// pair and merges their results. It implements the TargetProvider interface.
+type Discovery struct {
+	discoverers []*discoverer
+	logger      log.Logger
+}
+
+// NewDiscovery returns a new Discovery which periodically refreshes the
+// targets of every configured account. Its internal metrics are registered
+// on reg; if reg is nil, prometheus.DefaultRegisterer is used.
+func NewDiscovery(conf *SDConfig, logger log.Logger, reg prometheus.Registerer) (*Discovery, error) {
+	if logger == nil {
+		logger = log.Base()
+	}
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	p, err := matcher.NewPipeline(conf.Matchers)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile ECS SD matchers: %s", err)
+	}
+
+	ca := newCache(conf.CacheDir, time.Duration(conf.CacheTTL))
+
+	d := &Discovery{logger: logger}
+	for _, a := range conf.Accounts {
+		alias := a.alias()
+
+		m, err := newMetrics(reg, alias, a.Region)
+		if err != nil {
+			return nil, fmt.Errorf("could not register ECS SD metrics for account %q: %s", alias, err)
+		}
+
+		c, err := client.New(a.Region, a.RoleARN, a.ExternalID, m)
+		if err != nil {
+			return nil, fmt.Errorf("could not create ECS client for account %q: %s", alias, err)
+		}
+
+		d.discoverers = append(d.discoverers, &discoverer{
+			source:            fmt.Sprintf("ecs/%s/%s", alias, a.Region),
+			account:           alias,
+			region:            a.Region,
+			interval:          time.Duration(conf.RefreshInterval),
+			client:            c,
+			logger:            logger,
+			matchers:          p,
+			metrics:           m,
+			cache:             ca,
+			serveStaleOnError: conf.ServeStaleOnError,
+		})
+	}
+
+	return d, nil
+}
+
+// Run implements the TargetProvider interface. Each account/region pair is
+// refreshed on its own goroutine; their TargetGroups are merged onto ch
+// under stable per-account sources so Prometheus can correctly drop stale
+// targets if an account stops reporting.
+func (d *Discovery) Run(ctx context.Context, ch chan<- []*config.TargetGroup) {
+	var wg sync.WaitGroup
+	for _, disc := range d.discoverers {
+		wg.Add(1)
+		go func(disc *discoverer) {
+			defer wg.Done()
+			disc.run(ctx, ch)
+		}(disc)
+	}
+	wg.Wait()
+	close(ch)
+}
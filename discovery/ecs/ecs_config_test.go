@@ -0,0 +1,143 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/prometheus/prometheus/discovery/ecs/matcher"
+)
+
+// TestSDConfigUnmarshalYAML covers the validation errors and defaulting done
+// by SDConfig.UnmarshalYAML, which NewDiscovery itself relies on and never
+// re-checks.
+func TestSDConfigUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name:    "no accounts is an error",
+			yaml:    "accounts: []\n",
+			wantErr: "at least one account",
+		},
+		{
+			name: "missing region is an error",
+			yaml: `
+accounts:
+  - account_alias: prod
+`,
+			wantErr: "requires a region",
+		},
+		{
+			name: "second account missing region is reported by index",
+			yaml: `
+accounts:
+  - region: us-east-1
+  - account_alias: staging
+`,
+			wantErr: "account 1 requires a region",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			var c SDConfig
+			err := yaml.Unmarshal([]byte(tt.yaml), &c)
+			if assert.Error(err) {
+				assert.Contains(err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSDConfigUnmarshalYAMLDefaults checks that valid YAML parses into the
+// expected defaults: AccountAlias falling back to Region and
+// RefreshInterval falling back to DefaultRefreshInterval.
+func TestSDConfigUnmarshalYAMLDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	var c SDConfig
+	err := yaml.Unmarshal([]byte(`
+accounts:
+  - region: us-east-1
+  - region: us-west-2
+    account_alias: staging
+`), &c)
+	assert.NoError(err)
+
+	assert.Equal(DefaultRefreshInterval, c.RefreshInterval)
+	assert.Len(c.Accounts, 2)
+	assert.Equal("us-east-1", c.Accounts[0].alias())
+	assert.Equal("staging", c.Accounts[1].alias())
+}
+
+// TestNewDiscoveryWiring checks that NewDiscovery builds one discoverer per
+// configured account, under the documented "ecs/<alias>/<region>" source,
+// with its own client and metrics.
+func TestNewDiscoveryWiring(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := &SDConfig{
+		Accounts: []AccountConfig{
+			{Region: "us-east-1", AccountAlias: "prod"},
+			{Region: "us-west-2"},
+		},
+		RefreshInterval: model.Duration(30 * time.Second),
+	}
+
+	d, err := NewDiscovery(conf, nil, prometheus.NewRegistry())
+	assert.NoError(err)
+	if !assert.Len(d.discoverers, 2) {
+		return
+	}
+
+	prod, other := d.discoverers[0], d.discoverers[1]
+
+	assert.Equal("ecs/prod/us-east-1", prod.source)
+	assert.Equal("prod", prod.account)
+	assert.Equal("us-east-1", prod.region)
+
+	assert.Equal("ecs/us-west-2/us-west-2", other.source)
+	assert.Equal("us-west-2", other.account)
+	assert.Equal("us-west-2", other.region)
+
+	assert.NotNil(prod.client)
+	assert.NotNil(other.client)
+	assert.NotNil(prod.metrics)
+	assert.NotNil(other.metrics)
+	assert.NotSame(prod.metrics, other.metrics)
+}
+
+// TestNewDiscoveryInvalidMatcher checks that a matcher configuration error is
+// surfaced by NewDiscovery instead of silently building a no-op pipeline.
+func TestNewDiscoveryInvalidMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := &SDConfig{
+		Accounts: []AccountConfig{{Region: "us-east-1"}},
+		Matchers: []matcher.MatcherConfig{{Name: "bad", ContainerNameRegex: "("}},
+	}
+
+	_, err := NewDiscovery(conf, nil, prometheus.NewRegistry())
+	assert.Error(err)
+}
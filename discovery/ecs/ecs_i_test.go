@@ -73,6 +73,8 @@ func TestRun(t *testing.T) {
 				Targets: []model.LabelSet{
 					model.LabelSet{
 						"__address__":                        model.LabelValue("10.0.250.65:36112"),
+						"__meta_ecs_account":                 model.LabelValue("us-west-2"),
+						"__meta_ecs_region":                  model.LabelValue("us-west-2"),
 						"__meta_ecs_cluster":                 model.LabelValue("prod-cluster-infra"),
 						"__meta_ecs_service":                 model.LabelValue("myService"),
 						"__meta_ecs_image":                   model.LabelValue("000000000000.dkr.ecr.us-east-1.amazonaws.com/myCompany/myService:29f323e"),
@@ -87,6 +89,8 @@ func TestRun(t *testing.T) {
 					},
 					model.LabelSet{
 						"__address__":                        model.LabelValue("10.0.250.65:24567"),
+						"__meta_ecs_account":                 model.LabelValue("us-west-2"),
+						"__meta_ecs_region":                  model.LabelValue("us-west-2"),
 						"__meta_ecs_cluster":                 model.LabelValue("prod-cluster-infra"),
 						"__meta_ecs_service":                 model.LabelValue("myService"),
 						"__meta_ecs_image":                   model.LabelValue("000000000000.dkr.ecr.us-east-1.amazonaws.com/myCompany/myService:29f323e"),
@@ -101,6 +105,8 @@ func TestRun(t *testing.T) {
 					},
 					model.LabelSet{
 						"__address__":                        model.LabelValue("10.0.250.65:30987"),
+						"__meta_ecs_account":                 model.LabelValue("us-west-2"),
+						"__meta_ecs_region":                  model.LabelValue("us-west-2"),
 						"__meta_ecs_cluster":                 model.LabelValue("prod-cluster-infra"),
 						"__meta_ecs_service":                 model.LabelValue("myService"),
 						"__meta_ecs_image":                   model.LabelValue("nginx:latest"),
@@ -125,8 +131,10 @@ func TestRun(t *testing.T) {
 			Instances: test.instances,
 		}
 
-		d := Discovery{
-			source:   "us-west-2",
+		d := discoverer{
+			source:   "ecs/us-west-2/us-west-2",
+			account:  "us-west-2",
+			region:   "us-west-2",
 			interval: 10 * time.Millisecond,
 			client:   c,
 			logger:   log.Base(),
@@ -137,7 +145,7 @@ func TestRun(t *testing.T) {
 		defer ctx.Done()
 
 		// Run our discoverer with the mocked retriever.
-		go d.Run(ctx, ch)
+		go d.run(ctx, ch)
 
 		// Check multiple times.
 		counter := 5
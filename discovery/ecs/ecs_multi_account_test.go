@@ -0,0 +1,91 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery/ecs/client"
+	"github.com/prometheus/prometheus/discovery/ecs/types"
+)
+
+// TestDiscoveryRunMergesAccounts checks that Discovery.Run merges the target
+// groups of several accounts, each under its own stable source, even when
+// the accounts happen to run a cluster with the same name.
+func TestDiscoveryRunMergesAccounts(t *testing.T) {
+	assert := assert.New(t)
+
+	prodInstance := &types.ServiceInstance{
+		Cluster: "infra", Service: "api", Addr: "10.0.0.1:8080",
+		Container: "api", ContainerPort: "8080", ContainerPortProto: "tcp",
+	}
+	stagingInstance := &types.ServiceInstance{
+		Cluster: "infra", Service: "api", Addr: "10.1.0.1:8080",
+		Container: "api", ContainerPort: "8080", ContainerPortProto: "tcp",
+	}
+
+	d := &Discovery{
+		logger: log.Base(),
+		discoverers: []*discoverer{
+			{
+				source:   "ecs/prod/us-east-1",
+				account:  "prod",
+				region:   "us-east-1",
+				interval: 10 * time.Millisecond,
+				client:   &client.MockRetriever{Instances: []*types.ServiceInstance{prodInstance}},
+				logger:   log.Base(),
+			},
+			{
+				source:   "ecs/staging/us-west-2",
+				account:  "staging",
+				region:   "us-west-2",
+				interval: 10 * time.Millisecond,
+				client:   &client.MockRetriever{Instances: []*types.ServiceInstance{stagingInstance}},
+				logger:   log.Base(),
+			},
+		},
+	}
+
+	ch := make(chan []*config.TargetGroup)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx, ch)
+
+	seenSources := map[string]bool{}
+	for len(seenSources) < 2 {
+		tgs := <-ch
+		for _, tg := range tgs {
+			seenSources[tg.Source] = true
+			for _, target := range tg.Targets {
+				switch tg.Source {
+				case "ecs/prod/us-east-1":
+					assert.Equal(model.LabelValue("prod"), target[ecsLabelAccount])
+				case "ecs/staging/us-west-2":
+					assert.Equal(model.LabelValue("staging"), target[ecsLabelAccount])
+				}
+			}
+		}
+	}
+
+	assert.True(seenSources["ecs/prod/us-east-1"])
+	assert.True(seenSources["ecs/staging/us-west-2"])
+}
@@ -0,0 +1,201 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prometheus/prometheus/discovery/ecs/client"
+	"github.com/prometheus/prometheus/discovery/ecs/matcher"
+	"github.com/prometheus/prometheus/discovery/ecs/types"
+)
+
+func TestRefreshWithMatchers(t *testing.T) {
+	instances := []*types.ServiceInstance{
+		{
+			Cluster:            "prod-cluster-infra",
+			Service:            "myService",
+			Addr:               "10.0.250.65:36112",
+			Container:          "myService",
+			ContainerPort:      "8080",
+			ContainerPortProto: "tcp",
+			Image:              "myCompany/myService:29f323e",
+			Labels:             map[string]string{"monitor": "true", "kind": "main"},
+		},
+		{
+			Cluster:            "prod-cluster-infra",
+			Service:            "myService",
+			Addr:               "10.0.250.65:24567",
+			Container:          "myService",
+			ContainerPort:      "1568",
+			ContainerPortProto: "udp",
+			Image:              "myCompany/myService:29f323e",
+			Labels:             map[string]string{"monitor": "true", "kind": "main"},
+		},
+		{
+			Cluster:            "prod-cluster-infra",
+			Service:            "myService",
+			Addr:               "10.0.250.65:30987",
+			Container:          "nginx",
+			ContainerPort:      "8081",
+			ContainerPortProto: "tcp",
+			Image:              "nginx:latest",
+			Labels:             map[string]string{"kind": "front-http"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		matchers    []matcher.MatcherConfig
+		wantTargets []model.LabelSet
+	}{
+		{
+			name:     "no matchers configured emits every published port",
+			matchers: nil,
+			wantTargets: []model.LabelSet{
+				{model.AddressLabel: "10.0.250.65:36112"},
+				{model.AddressLabel: "10.0.250.65:24567"},
+				{model.AddressLabel: "10.0.250.65:30987"},
+			},
+		},
+		{
+			name: "label match excludes non matching containers",
+			matchers: []matcher.MatcherConfig{
+				{Name: "monitored", Labels: []matcher.LabelMatchConfig{{Label: "monitor", Value: "true"}}},
+			},
+			wantTargets: []model.LabelSet{
+				{model.AddressLabel: "10.0.250.65:36112"},
+				{model.AddressLabel: "10.0.250.65:24567"},
+			},
+		},
+		{
+			name: "port selector picks a single port out of the matched container",
+			matchers: []matcher.MatcherConfig{
+				{Name: "monitored", Port: matcher.PortSelectorConfig{Number: "8080"}},
+			},
+			wantTargets: []model.LabelSet{
+				{model.AddressLabel: "10.0.250.65:36112"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			p, err := matcher.NewPipeline(tt.matchers)
+			assert.NoError(err)
+
+			d := discoverer{
+				source:   "ecs/us-west-2/us-west-2",
+				account:  "us-west-2",
+				region:   "us-west-2",
+				interval: 10 * time.Millisecond,
+				client:   &client.MockRetriever{Instances: instances},
+				logger:   log.Base(),
+				matchers: p,
+			}
+
+			tg, err := d.refresh()
+			assert.NoError(err)
+			assert.Len(tg.Targets, len(tt.wantTargets))
+			for i, want := range tt.wantTargets {
+				assert.Equal(want[model.AddressLabel], tg.Targets[i][model.AddressLabel])
+			}
+		})
+	}
+}
+
+func TestRefreshServesStaleOnRetryableError(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "ecs-sd-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	instances := []*types.ServiceInstance{
+		{
+			Cluster: "prod-cluster-infra", Service: "myService",
+			Addr: "10.0.250.65:36112", Container: "myService",
+			ContainerPort: "8080", ContainerPortProto: "tcp",
+		},
+	}
+
+	d := discoverer{
+		source:   "ecs/us-west-2/us-west-2",
+		account:  "us-west-2",
+		region:   "us-west-2",
+		interval: 10 * time.Millisecond,
+		client: &client.MockRetriever{
+			Instances: instances,
+			Errs:      []error{nil, awserr.New("ThrottlingException", "slow down", nil)},
+		},
+		logger:            log.Base(),
+		cache:             newCache(dir, 0),
+		serveStaleOnError: true,
+	}
+
+	// First cycle succeeds and populates the cache.
+	tg, err := d.refresh()
+	assert.NoError(err)
+	assert.Len(tg.Targets, 1)
+
+	// Second cycle fails with a retryable error: the cached targets from
+	// the first cycle should still come back instead of an error.
+	tg, err = d.refresh()
+	assert.NoError(err)
+	assert.Len(tg.Targets, 1)
+	assert.Equal(model.LabelValue("10.0.250.65:36112"), tg.Targets[0][model.AddressLabel])
+}
+
+func TestRefreshDoesNotServeStaleOnNonRetryableError(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "ecs-sd-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	instances := []*types.ServiceInstance{
+		{Cluster: "prod-cluster-infra", Service: "myService", Addr: "10.0.250.65:36112"},
+	}
+
+	d := discoverer{
+		source:   "ecs/us-west-2/us-west-2",
+		account:  "us-west-2",
+		region:   "us-west-2",
+		interval: 10 * time.Millisecond,
+		client: &client.MockRetriever{
+			Instances: instances,
+			Errs:      []error{nil, errors.New("could not list clusters: boom")},
+		},
+		logger:            log.Base(),
+		cache:             newCache(dir, 0),
+		serveStaleOnError: true,
+	}
+
+	_, err = d.refresh()
+	assert.NoError(err)
+
+	_, err = d.refresh()
+	assert.Error(err, "a non-retryable error should not be swallowed by the cache fallback")
+}
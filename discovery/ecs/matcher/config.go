@@ -0,0 +1,191 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LabelMatchConfig is a single docker label condition. Exactly one of Value,
+// Regex or Exists should be set; if more than one is set, Value takes
+// precedence over Regex, which takes precedence over Exists.
+type LabelMatchConfig struct {
+	// Label is the docker label name to check.
+	Label string `yaml:"label"`
+	// Value requires the label to be set to this exact value.
+	Value string `yaml:"value,omitempty"`
+	// Regex requires the label value to match this regular expression.
+	Regex string `yaml:"regex,omitempty"`
+	// Exists requires the label to be set, regardless of its value.
+	Exists bool `yaml:"exists,omitempty"`
+}
+
+// PortSelectorConfig picks which of a matched container's published ports
+// become targets. The zero value selects every published port.
+type PortSelectorConfig struct {
+	// Number selects a single literal container port, e.g. "8080".
+	Number string `yaml:"number,omitempty"`
+	// Label names a docker label whose value is a comma separated list of
+	// container port numbers to select.
+	Label string `yaml:"label,omitempty"`
+}
+
+// MatcherConfig is the user facing, declarative configuration for a single
+// matching rule. Rules are tried in order; the first one whose container
+// name and label conditions are all satisfied decides which ports (if any)
+// become targets for that container.
+type MatcherConfig struct {
+	// Name identifies the rule and is exposed on every target it produces
+	// via the __meta_ecs_matcher label.
+	Name string `yaml:"name"`
+	// ContainerNameRegex, if set, must match the container name.
+	ContainerNameRegex string `yaml:"container_name_regex,omitempty"`
+	// Labels are docker label conditions that must all be satisfied.
+	Labels []LabelMatchConfig `yaml:"labels,omitempty"`
+	// Port selects which published port(s) become targets. Defaults to
+	// every published port.
+	Port PortSelectorConfig `yaml:"port,omitempty"`
+}
+
+// compiledLabelMatch is a LabelMatchConfig with its regex, if any, compiled
+// once up front.
+type compiledLabelMatch struct {
+	LabelMatchConfig
+	regex *regexp.Regexp
+}
+
+// configMatcher is the compiled, ready to use form of a MatcherConfig.
+type configMatcher struct {
+	name       string
+	nameRegex  *regexp.Regexp
+	labels     []compiledLabelMatch
+	portNumber string
+	portLabel  string
+}
+
+// New compiles a MatcherConfig into a Matcher, validating and pre-compiling
+// its regular expressions.
+func New(c MatcherConfig) (Matcher, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("matcher name cannot be empty")
+	}
+
+	m := &configMatcher{
+		name:       c.Name,
+		portNumber: c.Port.Number,
+		portLabel:  c.Port.Label,
+	}
+
+	if c.ContainerNameRegex != "" {
+		re, err := regexp.Compile(c.ContainerNameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid container_name_regex for matcher %q: %s", c.Name, err)
+		}
+		m.nameRegex = re
+	}
+
+	for _, l := range c.Labels {
+		if l.Label == "" {
+			return nil, fmt.Errorf("matcher %q: label conditions require a label name", c.Name)
+		}
+		cl := compiledLabelMatch{LabelMatchConfig: l}
+		if l.Value == "" && l.Regex != "" {
+			re, err := regexp.Compile(l.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("matcher %q: invalid regex for label %q: %s", c.Name, l.Label, err)
+			}
+			cl.regex = re
+		}
+		m.labels = append(m.labels, cl)
+	}
+
+	return m, nil
+}
+
+// NewPipeline compiles a list of MatcherConfigs into a Pipeline, in order.
+func NewPipeline(cs []MatcherConfig) (Pipeline, error) {
+	p := make(Pipeline, 0, len(cs))
+	for _, c := range cs {
+		m, err := New(c)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, m)
+	}
+	return p, nil
+}
+
+// Name implements Matcher.
+func (m *configMatcher) Name() string {
+	return m.name
+}
+
+// Match implements Matcher.
+func (m *configMatcher) Match(c Container) ([]Port, bool) {
+	if m.nameRegex != nil && !m.nameRegex.MatchString(c.Name) {
+		return nil, false
+	}
+	for _, l := range m.labels {
+		v, present := c.Labels[l.Label]
+		switch {
+		case l.Value != "":
+			if v != l.Value {
+				return nil, false
+			}
+		case l.regex != nil:
+			if !present || !l.regex.MatchString(v) {
+				return nil, false
+			}
+		case l.Exists:
+			if !present {
+				return nil, false
+			}
+		}
+	}
+
+	return m.selectPorts(c), true
+}
+
+// selectPorts applies the matcher's port selector to c's published ports.
+func (m *configMatcher) selectPorts(c Container) []Port {
+	switch {
+	case m.portNumber != "":
+		for _, p := range c.Ports {
+			if p.Number == m.portNumber {
+				return []Port{p}
+			}
+		}
+		return nil
+	case m.portLabel != "":
+		raw, ok := c.Labels[m.portLabel]
+		if !ok {
+			return nil
+		}
+		wanted := make(map[string]bool)
+		for _, n := range strings.Split(raw, ",") {
+			wanted[strings.TrimSpace(n)] = true
+		}
+		var ports []Port
+		for _, p := range c.Ports {
+			if wanted[p.Number] {
+				ports = append(ports, p)
+			}
+		}
+		return ports
+	default:
+		return c.Ports
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigMatcherMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    MatcherConfig
+		container Container
+		wantOK    bool
+		wantPorts []Port
+	}{
+		{
+			name:   "container name regex excludes non matching containers",
+			config: MatcherConfig{Name: "app", ContainerNameRegex: "^app-.*"},
+			container: Container{
+				Name:  "sidecar",
+				Ports: []Port{{Number: "8080", Protocol: "tcp"}},
+			},
+			wantOK: false,
+		},
+		{
+			name:   "container name regex includes matching containers",
+			config: MatcherConfig{Name: "app", ContainerNameRegex: "^app-.*"},
+			container: Container{
+				Name:  "app-web",
+				Ports: []Port{{Number: "8080", Protocol: "tcp"}},
+			},
+			wantOK:    true,
+			wantPorts: []Port{{Number: "8080", Protocol: "tcp"}},
+		},
+		{
+			name: "label exact value excludes when different",
+			config: MatcherConfig{
+				Name:   "monitored",
+				Labels: []LabelMatchConfig{{Label: "monitor", Value: "true"}},
+			},
+			container: Container{
+				Name:   "app",
+				Labels: map[string]string{"monitor": "false"},
+				Ports:  []Port{{Number: "8080", Protocol: "tcp"}},
+			},
+			wantOK: false,
+		},
+		{
+			name: "label exists excludes when absent",
+			config: MatcherConfig{
+				Name:   "monitored",
+				Labels: []LabelMatchConfig{{Label: "monitor", Exists: true}},
+			},
+			container: Container{
+				Name:  "app",
+				Ports: []Port{{Number: "8080", Protocol: "tcp"}},
+			},
+			wantOK: false,
+		},
+		{
+			name: "label regex includes on partial match",
+			config: MatcherConfig{
+				Name:   "monitored",
+				Labels: []LabelMatchConfig{{Label: "kind", Regex: "^front-"}},
+			},
+			container: Container{
+				Name:   "nginx",
+				Labels: map[string]string{"kind": "front-http"},
+				Ports:  []Port{{Number: "8081", Protocol: "tcp"}},
+			},
+			wantOK:    true,
+			wantPorts: []Port{{Number: "8081", Protocol: "tcp"}},
+		},
+		{
+			name: "port number selector picks a single port",
+			config: MatcherConfig{
+				Name: "app",
+				Port: PortSelectorConfig{Number: "1568"},
+			},
+			container: Container{
+				Name: "app",
+				Ports: []Port{
+					{Number: "8080", Protocol: "tcp"},
+					{Number: "1568", Protocol: "udp"},
+				},
+			},
+			wantOK:    true,
+			wantPorts: []Port{{Number: "1568", Protocol: "udp"}},
+		},
+		{
+			name: "port number selector excludes when port is not published",
+			config: MatcherConfig{
+				Name: "app",
+				Port: PortSelectorConfig{Number: "9999"},
+			},
+			container: Container{
+				Name:  "app",
+				Ports: []Port{{Number: "8080", Protocol: "tcp"}},
+			},
+			wantOK: true,
+		},
+		{
+			name: "port label selector picks the listed ports",
+			config: MatcherConfig{
+				Name: "app",
+				Port: PortSelectorConfig{Label: "prometheus.ports"},
+			},
+			container: Container{
+				Name:   "app",
+				Labels: map[string]string{"prometheus.ports": "8080, 9090"},
+				Ports: []Port{
+					{Number: "8080", Protocol: "tcp"},
+					{Number: "8081", Protocol: "tcp"},
+					{Number: "9090", Protocol: "tcp"},
+				},
+			},
+			wantOK:    true,
+			wantPorts: []Port{{Number: "8080", Protocol: "tcp"}, {Number: "9090", Protocol: "tcp"}},
+		},
+		{
+			name:   "no port selector defaults to all published ports",
+			config: MatcherConfig{Name: "app"},
+			container: Container{
+				Name: "app",
+				Ports: []Port{
+					{Number: "8080", Protocol: "tcp"},
+					{Number: "1568", Protocol: "udp"},
+				},
+			},
+			wantOK: true,
+			wantPorts: []Port{
+				{Number: "8080", Protocol: "tcp"},
+				{Number: "1568", Protocol: "udp"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			m, err := New(tt.config)
+			assert.NoError(err)
+
+			ports, ok := m.Match(tt.container)
+			assert.Equal(tt.wantOK, ok)
+			assert.Equal(tt.wantPorts, ports)
+		})
+	}
+}
+
+func TestNewPipelineMatchesInOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := NewPipeline([]MatcherConfig{
+		{Name: "web", ContainerNameRegex: "^web-.*"},
+		{Name: "catch-all"},
+	})
+	assert.NoError(err)
+
+	name, ports, ok := p.Match(Container{
+		Name:  "web-front",
+		Ports: []Port{{Number: "80", Protocol: "tcp"}},
+	})
+	assert.True(ok)
+	assert.Equal("web", name)
+	assert.Equal([]Port{{Number: "80", Protocol: "tcp"}}, ports)
+
+	name, _, ok = p.Match(Container{Name: "worker"})
+	assert.True(ok)
+	assert.Equal("catch-all", name)
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package matcher decides which ECS containers, and which of their
+// published ports, should become Prometheus scrape targets. It mirrors the
+// docker-label based matcher pipeline used by AWS's own ECS observer for
+// OpenTelemetry.
+package matcher
+
+// Port is a single port published by a container.
+type Port struct {
+	// Number is the container side port number, e.g. "8080".
+	Number string
+	// Protocol is "tcp" or "udp".
+	Protocol string
+}
+
+// Container is the matcher's view of a container: everything a Matcher might
+// need to decide whether it applies and which of its ports to select.
+type Container struct {
+	// Name is the container name, as defined in the task definition.
+	Name string
+	// Labels are the container's docker labels.
+	Labels map[string]string
+	// Ports holds every port published by the container.
+	Ports []Port
+}
+
+// Matcher decides whether a Container should be scraped and, when it
+// matches, which of its published ports should become targets.
+type Matcher interface {
+	// Name identifies the matcher. It is exposed on every target it produces
+	// via the __meta_ecs_matcher label.
+	Name() string
+	// Match reports whether c is matched by this rule and, if so, the subset
+	// of c.Ports that should be turned into targets.
+	Match(c Container) (ports []Port, ok bool)
+}
+
+// Pipeline is an ordered list of Matchers. The first Matcher that matches a
+// given Container wins; later ones are not consulted.
+type Pipeline []Matcher
+
+// Match runs c through the pipeline in order and returns the first matching
+// Matcher's name and selected ports. ok is false if no Matcher in the
+// pipeline matches c.
+func (p Pipeline) Match(c Container) (name string, ports []Port, ok bool) {
+	for _, m := range p {
+		if ports, ok := m.Match(c); ok {
+			return m.Name(), ports, true
+		}
+	}
+	return "", nil, false
+}
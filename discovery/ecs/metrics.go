@@ -0,0 +1,228 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "prometheus"
+	metricsSubsystem = "sd_ecs"
+)
+
+// Metrics holds the prometheus.Collectors used to instrument a single
+// account/region discoverer, so operators can alert on a stuck or throttled
+// ECS SD.
+type Metrics struct {
+	refreshesTotal  *prometheus.CounterVec
+	refreshDuration prometheus.Histogram
+	clusters        prometheus.Gauge
+	services        prometheus.Gauge
+	targets         prometheus.Gauge
+	apiCallsTotal   *prometheus.CounterVec
+}
+
+// newMetrics creates and registers the collectors for a discoverer running
+// against account/region on reg. If a collector with the same name and
+// labels is already registered - as happens when Prometheus reloads its
+// config and rebuilds the same account/region's Discovery - the existing
+// collector is reused instead of returning an AlreadyRegisteredError.
+func newMetrics(reg prometheus.Registerer, account, region string) (*Metrics, error) {
+	constLabels := prometheus.Labels{"account": account, "region": region}
+	m := &Metrics{}
+	var err error
+
+	if m.refreshesTotal, err = registerCounterVec(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Subsystem:   metricsSubsystem,
+		Name:        "refreshes_total",
+		Help:        `Total number of ECS SD refresh cycles, by error class ("" means success).`,
+		ConstLabels: constLabels,
+	}, []string{"error_class"})); err != nil {
+		return nil, err
+	}
+
+	if m.refreshDuration, err = registerHistogram(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   metricsNamespace,
+		Subsystem:   metricsSubsystem,
+		Name:        "refresh_duration_seconds",
+		Help:        "Duration of a full ECS SD refresh cycle.",
+		ConstLabels: constLabels,
+		Buckets:     prometheus.DefBuckets,
+	})); err != nil {
+		return nil, err
+	}
+
+	if m.clusters, err = registerGauge(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Subsystem:   metricsSubsystem,
+		Name:        "clusters",
+		Help:        "Number of ECS clusters observed on the last refresh cycle.",
+		ConstLabels: constLabels,
+	})); err != nil {
+		return nil, err
+	}
+
+	if m.services, err = registerGauge(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Subsystem:   metricsSubsystem,
+		Name:        "services",
+		Help:        "Number of ECS services observed on the last refresh cycle.",
+		ConstLabels: constLabels,
+	})); err != nil {
+		return nil, err
+	}
+
+	if m.targets, err = registerGauge(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Subsystem:   metricsSubsystem,
+		Name:        "targets",
+		Help:        "Number of targets produced on the last refresh cycle.",
+		ConstLabels: constLabels,
+	})); err != nil {
+		return nil, err
+	}
+
+	if m.apiCallsTotal, err = registerCounterVec(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Subsystem:   metricsSubsystem,
+		Name:        "api_calls_total",
+		Help:        "Total number of AWS API calls made by the ECS discoverer, by endpoint.",
+		ConstLabels: constLabels,
+	}, []string{"endpoint"})); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// registerOrReuse registers c on reg, returning the already-registered
+// collector instead of an error if an equivalent collector (same fully
+// qualified name and const labels) was registered before.
+func registerOrReuse(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func registerCounterVec(reg prometheus.Registerer, c *prometheus.CounterVec) (*prometheus.CounterVec, error) {
+	existing, err := registerOrReuse(reg, c)
+	if err != nil {
+		return nil, err
+	}
+	return existing.(*prometheus.CounterVec), nil
+}
+
+func registerGauge(reg prometheus.Registerer, g prometheus.Gauge) (prometheus.Gauge, error) {
+	existing, err := registerOrReuse(reg, g)
+	if err != nil {
+		return nil, err
+	}
+	return existing.(prometheus.Gauge), nil
+}
+
+func registerHistogram(reg prometheus.Registerer, h prometheus.Histogram) (prometheus.Histogram, error) {
+	existing, err := registerOrReuse(reg, h)
+	if err != nil {
+		return nil, err
+	}
+	return existing.(prometheus.Histogram), nil
+}
+
+// observeRefresh records the outcome and duration of a single refresh cycle.
+// It is nil-receiver safe so a Discovery built without metrics (as in tests)
+// keeps working.
+func (m *Metrics) observeRefresh(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.refreshDuration.Observe(d.Seconds())
+	m.refreshesTotal.WithLabelValues(errorClass(err)).Inc()
+}
+
+// setCounts sets the last-cycle cluster/service/target gauges.
+func (m *Metrics) setCounts(clusters, services, targets int) {
+	if m == nil {
+		return
+	}
+	m.clusters.Set(float64(clusters))
+	m.services.Set(float64(services))
+	m.targets.Set(float64(targets))
+}
+
+// IncAPICall implements client.APICallRecorder.
+func (m *Metrics) IncAPICall(endpoint string) {
+	if m == nil {
+		return
+	}
+	m.apiCallsTotal.WithLabelValues(endpoint).Inc()
+}
+
+// errorClass buckets a refresh error into a small, low-cardinality class so
+// it's safe to use as a metric label. err is unwrapped with errors.As since
+// Client.Retrieve wraps the underlying awserr.Error/net.Error in layers of
+// added context as it bubbles up.
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded":
+			return "throttling"
+		case "UnauthorizedOperation", "AccessDenied", "AccessDeniedException":
+			return "auth"
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "timeout"
+	}
+	return "other"
+}
+
+// isRetryableError reports whether err looks like a transient failure
+// (throttling, a 5xx AWS error, or a network error) that is worth falling
+// back to a cached snapshot for. Anything else - an auth error, or an error
+// that isn't even an AWS/network error, such as a bug in our own code - is
+// left to surface normally, since a cached snapshot wouldn't help with it
+// and silently masking it would hide a real, non-transient problem. err is
+// unwrapped with errors.As for the same reason as errorClass.
+func isRetryableError(err error) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded":
+			return true
+		}
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() >= 500 {
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
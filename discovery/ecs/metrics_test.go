@@ -0,0 +1,94 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "nil error is success", err: nil, want: ""},
+		{name: "throttling", err: awserr.New("ThrottlingException", "slow down", nil), want: "throttling"},
+		{name: "auth", err: awserr.New("AccessDenied", "nope", nil), want: "auth"},
+		{name: "unknown aws error", err: awserr.New("InternalError", "oops", nil), want: "other"},
+		{name: "plain error", err: errors.New("boom"), want: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, errorClass(tt.err))
+		})
+	}
+}
+
+func TestNewMetricsRegistersAndUpdates(t *testing.T) {
+	assert := assert.New(t)
+	reg := prometheus.NewRegistry()
+
+	m, err := newMetrics(reg, "prod", "eu-west-1")
+	assert.NoError(err)
+
+	m.observeRefresh(250*time.Millisecond, nil)
+	m.observeRefresh(time.Second, errors.New("boom"))
+	m.setCounts(3, 5, 42)
+	m.IncAPICall("ListClusters")
+	m.IncAPICall("ListClusters")
+	m.IncAPICall("DescribeTasks")
+
+	assert.Equal(1.0, testutil.ToFloat64(m.refreshesTotal.WithLabelValues("")))
+	assert.Equal(1.0, testutil.ToFloat64(m.refreshesTotal.WithLabelValues("other")))
+	assert.Equal(3.0, testutil.ToFloat64(m.clusters))
+	assert.Equal(5.0, testutil.ToFloat64(m.services))
+	assert.Equal(42.0, testutil.ToFloat64(m.targets))
+	assert.Equal(2.0, testutil.ToFloat64(m.apiCallsTotal.WithLabelValues("ListClusters")))
+	assert.Equal(1.0, testutil.ToFloat64(m.apiCallsTotal.WithLabelValues("DescribeTasks")))
+	assert.Equal(1, testutil.CollectAndCount(m.refreshDuration))
+}
+
+// TestNewMetricsReregistration verifies that building metrics for the same
+// account/region twice - as happens when Prometheus reloads its config and
+// rebuilds the same account's Discovery - reuses the already-registered
+// collectors instead of failing with an AlreadyRegisteredError.
+func TestNewMetricsReregistration(t *testing.T) {
+	assert := assert.New(t)
+	reg := prometheus.NewRegistry()
+
+	first, err := newMetrics(reg, "prod", "eu-west-1")
+	assert.NoError(err)
+
+	second, err := newMetrics(reg, "prod", "eu-west-1")
+	assert.NoError(err)
+
+	second.setCounts(1, 2, 3)
+	assert.Equal(3.0, testutil.ToFloat64(first.targets))
+
+	// Metrics for a different account/region are distinct series.
+	other, err := newMetrics(reg, "staging", "eu-west-1")
+	assert.NoError(err)
+	other.setCounts(9, 9, 9)
+	assert.Equal(3.0, testutil.ToFloat64(first.targets))
+	assert.Equal(9.0, testutil.ToFloat64(other.targets))
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package types holds the data shared between the ECS client and the
+// discoverer, kept separate so both can depend on it without an import
+// cycle.
+package types
+
+// ServiceInstance is a single (container, published port) pair discovered on
+// an ECS cluster. A container that publishes several ports produces one
+// ServiceInstance per port, all sharing the same Cluster, Service and
+// Container but with a different Addr/ContainerPort.
+type ServiceInstance struct {
+	// Cluster is the name of the ECS cluster the task is running on.
+	Cluster string
+	// Service is the name of the ECS service that owns the task.
+	Service string
+	// Addr is the host:port Prometheus should scrape.
+	Addr string
+	// Container is the name of the container, as defined in the task
+	// definition.
+	Container string
+	// ContainerPort is the container side port number of this instance.
+	ContainerPort string
+	// ContainerPortProto is the port's protocol, "tcp" or "udp".
+	ContainerPortProto string
+	// Image is the docker image (including tag) the container runs.
+	Image string
+	// Labels are the docker labels set on the container, as defined in its
+	// task definition's container definition.
+	Labels map[string]string
+	// Tags are the AWS tags set on the EC2 instance the task's container
+	// instance runs on.
+	Tags map[string]string
+}